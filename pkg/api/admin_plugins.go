@@ -0,0 +1,63 @@
+package api
+
+import (
+	m "github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/plugins"
+)
+
+// EnablePlugin (re-)starts a backend datasource plugin's subprocess and
+// re-registers its tsdb query endpoint.
+func (hs *HTTPServer) EnablePlugin(c *m.ReqContext) Response {
+	ds, exists := plugins.GetDataSource(c.Params(":id"))
+	if !exists {
+		return Error(404, "Plugin not found", nil)
+	}
+
+	if err := ds.Enable(c.Req.Context()); err != nil {
+		return Error(500, "Failed to enable plugin", err)
+	}
+
+	return Success("Plugin enabled")
+}
+
+// DisablePlugin stops a backend datasource plugin's subprocess so queries
+// against it fail fast, without removing it from disk.
+func (hs *HTTPServer) DisablePlugin(c *m.ReqContext) Response {
+	ds, exists := plugins.GetDataSource(c.Params(":id"))
+	if !exists {
+		return Error(404, "Plugin not found", nil)
+	}
+
+	if err := ds.Disable(c.Req.Context()); err != nil {
+		return Error(500, "Failed to disable plugin", err)
+	}
+
+	return Success("Plugin disabled")
+}
+
+// GetPluginCrashes returns the recent crash history (panic traces captured
+// from the subprocess's stderr) recorded for a backend datasource plugin.
+func (hs *HTTPServer) GetPluginCrashes(c *m.ReqContext) Response {
+	ds, exists := plugins.GetDataSource(c.Params(":id"))
+	if !exists {
+		return Error(404, "Plugin not found", nil)
+	}
+
+	return JSON(200, ds.Crashes())
+}
+
+// ReloadPlugin restarts a backend datasource plugin's subprocess, draining
+// in-flight queries first. Use it to pick up a new executable, manifest or
+// signature without restarting Grafana.
+func (hs *HTTPServer) ReloadPlugin(c *m.ReqContext) Response {
+	ds, exists := plugins.GetDataSource(c.Params(":id"))
+	if !exists {
+		return Error(404, "Plugin not found", nil)
+	}
+
+	if err := ds.Reload(c.Req.Context()); err != nil {
+		return Error(500, "Failed to reload plugin", err)
+	}
+
+	return Success("Plugin reloaded")
+}
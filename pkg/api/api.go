@@ -0,0 +1,28 @@
+package api
+
+import "github.com/grafana/grafana/pkg/api/routing"
+
+// registerPluginRoutes wires the plugin distribution, lifecycle and crash
+// endpoints added alongside DataSourcePlugin into the router.
+//
+// HTTPServer.registerRoutes already exists and builds the rest of /api;
+// add "hs.registerPluginRoutes(hs.RouteRegister)" as a line inside that
+// existing method rather than declaring a second registerRoutes here —
+// HTTPServer can only have one method of that name.
+func (hs *HTTPServer) registerPluginRoutes(r routing.RouteRegister) {
+	r.Group("/api/plugins", func(pluginRoute routing.RouteRegister) {
+		pluginRoute.Post("/install", reqGrafanaAdmin, routing.Wrap(hs.InstallPlugin))
+		pluginRoute.Delete("/:id", reqGrafanaAdmin, routing.Wrap(hs.RemovePlugin))
+		pluginRoute.Get("/:id/privileges", reqGrafanaAdmin, routing.Wrap(hs.GetPluginPrivileges))
+		pluginRoute.Post("/:id/privileges/ack", reqGrafanaAdmin, routing.Wrap(hs.AcknowledgePluginPrivileges))
+		pluginRoute.Get("/:id/metrics", reqGrafanaAdmin, routing.Wrap(hs.GetPluginMetrics))
+		pluginRoute.Get("/:id/queries/:queryId/progress", reqSignedIn, hs.StreamQueryProgress)
+	}, reqSignedIn)
+
+	r.Group("/api/admin/plugins", func(adminRoute routing.RouteRegister) {
+		adminRoute.Post("/:id/enable", routing.Wrap(hs.EnablePlugin))
+		adminRoute.Post("/:id/disable", routing.Wrap(hs.DisablePlugin))
+		adminRoute.Post("/:id/reload", routing.Wrap(hs.ReloadPlugin))
+		adminRoute.Get("/:id/crashes", routing.Wrap(hs.GetPluginCrashes))
+	}, reqGrafanaAdmin)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	m "github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/plugins"
+)
+
+// GetPluginMetrics returns the most recent Prometheus-style sample a v2 SDK
+// plugin has reported for each metric name over the stdio frame channel.
+func (hs *HTTPServer) GetPluginMetrics(c *m.ReqContext) Response {
+	ds, exists := plugins.GetDataSource(c.Params(":id"))
+	if !exists {
+		return Error(404, "Plugin not found", nil)
+	}
+
+	return JSON(200, ds.MetricSamples())
+}
+
+// StreamQueryProgress writes newline-delimited JSON ProgressEvents for a
+// single in-flight query as a v2 SDK plugin reports them, until the query's
+// progress channel is closed or the client disconnects. It writes directly
+// to the response instead of returning a Response, since it streams rather
+// than answering with one body.
+//
+// :queryId only receives progress for a query the caller also started with
+// the same id attached via plugins.ContextWithQueryID — callers that want
+// to watch a query's progress must generate the id themselves and pass it
+// to both the query request and this endpoint.
+func (hs *HTTPServer) StreamQueryProgress(c *m.ReqContext) {
+	ds, exists := plugins.GetDataSource(c.Params(":id"))
+	if !exists {
+		http.Error(c.Resp, "Plugin not found", http.StatusNotFound)
+		return
+	}
+
+	queryID := c.Params(":queryId")
+	progress := ds.ProgressChannel(queryID)
+	defer ds.CloseProgressChannel(queryID)
+
+	flusher, ok := c.Resp.(http.Flusher)
+	c.Resp.Header().Set("Content-Type", "application/x-ndjson")
+	c.Resp.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(c.Resp)
+	for {
+		select {
+		case evt, open := <-progress:
+			if !open {
+				return
+			}
+			if err := enc.Encode(evt); err != nil {
+				return
+			}
+			if ok {
+				flusher.Flush()
+			}
+		case <-c.Req.Context().Done():
+			return
+		}
+	}
+}
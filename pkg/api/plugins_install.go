@@ -0,0 +1,60 @@
+package api
+
+import (
+	m "github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/plugins"
+)
+
+// InstallPluginCmd is the payload for POST /api/plugins/install.
+type InstallPluginCmd struct {
+	Name    string `json:"name" binding:"Required"`
+	Version string `json:"version" binding:"Required"`
+}
+
+// InstallPlugin fetches, verifies and unpacks a plugin bundle from the
+// configured registry and registers it, without requiring a filesystem
+// hand-copy or a restart.
+func (hs *HTTPServer) InstallPlugin(c *m.ReqContext, cmd InstallPluginCmd) Response {
+	if _, err := plugins.InstallPlugin(c.Req.Context(), cmd.Name, cmd.Version); err != nil {
+		return Error(500, "Failed to install plugin", err)
+	}
+
+	return Success("Plugin installed")
+}
+
+// RemovePlugin kills a running plugin and deletes it from disk.
+func (hs *HTTPServer) RemovePlugin(c *m.ReqContext) Response {
+	if err := plugins.RemovePlugin(c.Params(":id")); err != nil {
+		return Error(500, "Failed to remove plugin", err)
+	}
+
+	return Success("Plugin removed")
+}
+
+// AcknowledgePluginPrivileges approves a plugin's currently declared
+// privileges, allowing it to start. Required again after upgrading a
+// plugin whose declared privileges changed.
+func (hs *HTTPServer) AcknowledgePluginPrivileges(c *m.ReqContext) Response {
+	ds, exists := plugins.GetDataSource(c.Params(":id"))
+	if !exists {
+		return Error(404, "Plugin not found", nil)
+	}
+
+	if err := ds.AcknowledgePrivileges(); err != nil {
+		return Error(500, "Failed to acknowledge plugin privileges", err)
+	}
+
+	return Success("Plugin privileges acknowledged")
+}
+
+// GetPluginPrivileges lists the network routes, filesystem paths and
+// executables a plugin's manifest declares it needs, so an admin can
+// review them before enabling it.
+func (hs *HTTPServer) GetPluginPrivileges(c *m.ReqContext) Response {
+	privileges, err := plugins.GetPluginPrivileges(c.Params(":id"))
+	if err != nil {
+		return Error(404, "Plugin not found", err)
+	}
+
+	return JSON(200, privileges)
+}
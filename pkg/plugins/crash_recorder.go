@@ -0,0 +1,162 @@
+package plugins
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCrashTraceLines bounds how many stderr lines of a single panic we
+	// keep, so a runaway stack trace (or a plugin that never stops writing)
+	// can't grow the buffer without bound.
+	maxCrashTraceLines = 200
+	// maxCrashHistory bounds how many past crash reports we keep per plugin.
+	maxCrashHistory = 20
+
+	// crashWindow is the sliding window the circuit breaker counts crashes
+	// over.
+	crashWindow = 5 * time.Minute
+	// maxCrashesInWindow is how many crashes within crashWindow mark the
+	// plugin Failed instead of restarting it again.
+	maxCrashesInWindow = 5
+
+	// restartBaseBackoff and restartMaxBackoff bound the exponential backoff
+	// applied between restart attempts after a crash.
+	restartBaseBackoff = time.Second
+	restartMaxBackoff  = 30 * time.Second
+)
+
+var panicLineRe = regexp.MustCompile(`^panic:`)
+
+// CrashReport is the structured record of a single plugin subprocess crash,
+// returned by GET /api/admin/plugins/:id/crashes.
+type CrashReport struct {
+	PluginId string    `json:"pluginId"`
+	Time     time.Time `json:"time"`
+	Trace    string    `json:"trace"`
+}
+
+// crashRecorder watches a plugin subprocess's stderr for a Go panic
+// signature (a "panic:" line followed by a "goroutine N [running]:" header
+// and stack frames) and buffers the lines so they can be flushed into a
+// CrashReport once the process exits.
+type crashRecorder struct {
+	mu        sync.Mutex
+	capturing bool
+	lines     []string
+}
+
+func (r *crashRecorder) observe(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.capturing {
+		if !panicLineRe.MatchString(strings.TrimSpace(line)) {
+			return
+		}
+		r.capturing = true
+	}
+
+	r.lines = append(r.lines, line)
+	if len(r.lines) > maxCrashTraceLines {
+		r.lines = r.lines[len(r.lines)-maxCrashTraceLines:]
+	}
+}
+
+// flush returns the buffered trace (if any) and resets the recorder for the
+// next subprocess instance.
+func (r *crashRecorder) flush() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	trace := strings.Join(r.lines, "\n")
+	r.capturing = false
+	r.lines = nil
+	return trace
+}
+
+// stderrPanicScanner is handed to go-plugin as ClientConfig.SyncStderr, so
+// the subprocess's raw stderr is duplicated to it alongside go-plugin's own
+// handling. We used to instead wrap the plugin's hclog.Logger and override
+// Debug/Trace, but go-plugin calls Named()/With() on that logger before
+// ever logging a line, and LogWrapper's Named() returns a plain LogWrapper
+// — silently dropping our override and the recorder with it. Scanning the
+// raw bytes ourselves doesn't depend on go-plugin's logger plumbing at all.
+type stderrPanicScanner struct {
+	recorder *crashRecorder
+	buf      []byte
+}
+
+func (s *stderrPanicScanner) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+		s.recorder.observe(string(s.buf[:i]))
+		s.buf = s.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// recordCrash buffers a crash report, appends it to the plugin's history and
+// reports whether the circuit breaker has now tripped (maxCrashesInWindow
+// crashes within crashWindow), in which case the plugin should be marked
+// Failed rather than restarted again.
+func (p *DataSourcePlugin) recordCrash(trace string) (tripped bool) {
+	now := time.Now()
+
+	p.crashMu.Lock()
+	defer p.crashMu.Unlock()
+
+	p.crashReports = append(p.crashReports, CrashReport{PluginId: p.Id, Time: now, Trace: trace})
+	if len(p.crashReports) > maxCrashHistory {
+		p.crashReports = p.crashReports[len(p.crashReports)-maxCrashHistory:]
+	}
+
+	p.crashTimes = append(p.crashTimes, now)
+	cutoff := now.Add(-crashWindow)
+	live := p.crashTimes[:0]
+	for _, t := range p.crashTimes {
+		if t.After(cutoff) {
+			live = append(live, t)
+		}
+	}
+	p.crashTimes = live
+
+	return len(p.crashTimes) >= maxCrashesInWindow
+}
+
+// restartBackoff returns how long to wait before the next restart attempt,
+// growing exponentially with the number of crashes seen in the current
+// window and capped at restartMaxBackoff.
+func (p *DataSourcePlugin) restartBackoff() time.Duration {
+	p.crashMu.Lock()
+	n := len(p.crashTimes)
+	p.crashMu.Unlock()
+
+	backoff := restartBaseBackoff
+	for i := 1; i < n; i++ {
+		backoff *= 2
+		if backoff >= restartMaxBackoff {
+			return restartMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// Crashes returns the plugin's recent crash history, most recent last.
+func (p *DataSourcePlugin) Crashes() []CrashReport {
+	p.crashMu.Lock()
+	defer p.crashMu.Unlock()
+
+	out := make([]CrashReport, len(p.crashReports))
+	copy(out, p.crashReports)
+	return out
+}
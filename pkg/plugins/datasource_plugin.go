@@ -5,14 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
-	"path"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana/pkg/setting"
 
 	datasourceV1 "github.com/grafana/grafana-plugin-model/go/datasource"
 	sdk "github.com/grafana/grafana-plugin-sdk-go"
+	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/plugins/datasource/wrapper"
@@ -41,8 +43,41 @@ type DataSourcePlugin struct {
 	Executable string `json:"executable,omitempty"`
 	SDK        bool   `json:"sdk,omitempty"`
 
+	// Privileges declares what the backend subprocess needs: network
+	// routes, filesystem paths and allowed executables. It has no entry for
+	// environment variables; the subprocess inherits Grafana's whole
+	// environment. An admin must acknowledge it (AcknowledgePrivileges)
+	// before the plugin will start; spawnSubProcess refuses to run it if
+	// this has escalated since.
+	Privileges PluginPrivileges `json:"privileges,omitempty"`
+
 	log    log.Logger
 	client *plugin.Client
+
+	mu       sync.Mutex
+	state    PluginState
+	inFlight sync.WaitGroup
+
+	// lifecycleMu serializes Enable, Disable, Reload and the restart
+	// watcher's own respawns, so only one spawn/kill is ever in flight for
+	// this plugin at a time. Without it, e.g. two concurrent Enables (or an
+	// Enable racing the watcher's post-crash respawn) each spawn a
+	// subprocess, and setClient silently orphans whichever one loses.
+	lifecycleMu sync.Mutex
+	// watcherRunning is guarded by lifecycleMu; it's true from the moment a
+	// restart watcher goroutine is launched until it returns (crash circuit
+	// breaker trip, or its context being cancelled).
+	watcherRunning bool
+
+	crashes      *crashRecorder
+	crashMu      sync.Mutex
+	crashTimes   []time.Time
+	crashReports []CrashReport
+
+	metrics  *metricsRegistry
+	progress *progressRouter
+
+	stopRestartWatcher context.CancelFunc
 }
 
 func (p *DataSourcePlugin) Load(decoder *json.Decoder, pluginDir string) error {
@@ -58,10 +93,29 @@ func (p *DataSourcePlugin) Load(decoder *json.Decoder, pluginDir string) error {
 		return err
 	}
 
-	DataSources[p.Id] = p
+	p.log = log.New("plugin-id", p.Id)
+	p.metrics = newMetricsRegistry()
+	p.progress = newProgressRouter()
+
+	p.setState(PluginStateLoaded)
+	setDataSource(p.Id, p)
 	return nil
 }
 
+// State returns the plugin's current position in its lifecycle.
+func (p *DataSourcePlugin) State() PluginState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+func (p *DataSourcePlugin) setState(s PluginState) {
+	p.mu.Lock()
+	p.state = s
+	p.mu.Unlock()
+	bus.Publish(&PluginStateChanged{PluginId: p.Id, State: s})
+}
+
 var handshakeConfig = plugin.HandshakeConfig{
 	ProtocolVersion:  1,
 	MagicCookieKey:   "grafana_plugin_type",
@@ -71,25 +125,153 @@ var handshakeConfig = plugin.HandshakeConfig{
 func (p *DataSourcePlugin) startBackendPlugin(ctx context.Context, log log.Logger) error {
 	p.log = log.New("plugin-id", p.Id)
 
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+
 	if err := p.spawnSubProcess(); err != nil {
+		p.setState(PluginStateFailed)
 		return err
 	}
 
+	p.setState(PluginStateEnabled)
+	p.ensureRestartWatcherLocked(ctx)
+
+	return nil
+}
+
+// ensureRestartWatcherLocked starts the watcher goroutine that restarts a
+// crashed subprocess, unless one is already running for this plugin.
+// Callers must hold lifecycleMu.
+func (p *DataSourcePlugin) ensureRestartWatcherLocked(ctx context.Context) {
+	if p.watcherRunning {
+		return
+	}
+
+	watcherCtx, cancel := context.WithCancel(ctx)
+	p.stopRestartWatcher = cancel
+	p.watcherRunning = true
+
 	go func() {
-		if err := p.restartKilledProcess(ctx); err != nil {
+		if err := p.restartKilledProcess(watcherCtx); err != nil {
 			p.log.Error("Attempting to restart killed process failed", "err", err)
 		}
+
+		p.lifecycleMu.Lock()
+		p.watcherRunning = false
+		p.lifecycleMu.Unlock()
 	}()
+}
+
+// Enable (re-)spawns the plugin's backend subprocess, re-registers its tsdb
+// query endpoint and makes sure a restart watcher is running for it. It is
+// a no-op if the plugin is already enabled.
+//
+// The watcher goroutine started the first time this plugin was enabled
+// returns for good once it trips the crash circuit breaker (PluginStateFailed)
+// or ctx is cancelled; without relaunching one here, an Enable after either
+// of those would run the plugin with no crash capture and no auto-restart.
+func (p *DataSourcePlugin) Enable(ctx context.Context) error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	return p.enableLocked(ctx)
+}
 
+// enableLocked is Enable's body; callers must already hold lifecycleMu.
+func (p *DataSourcePlugin) enableLocked(ctx context.Context) error {
+	if p.State() == PluginStateEnabled {
+		return nil
+	}
+
+	if err := p.spawnSubProcess(); err != nil {
+		p.setState(PluginStateFailed)
+		return err
+	}
+
+	p.setState(PluginStateEnabled)
+	p.ensureRestartWatcherLocked(ctx)
+	p.log.Debug("Plugin enabled")
+	return nil
+}
+
+// Disable marks the plugin disabled so its tsdb query endpoint fails fast,
+// then kills its backend subprocess.
+func (p *DataSourcePlugin) Disable(ctx context.Context) error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	return p.disableLocked(ctx)
+}
+
+// disableLocked is Disable's body; callers must already hold lifecycleMu.
+func (p *DataSourcePlugin) disableLocked(ctx context.Context) error {
+	p.setState(PluginStateDisabled)
+
+	if client := p.getClient(); client != nil {
+		client.Kill()
+	}
+
+	p.log.Debug("Plugin disabled")
 	return nil
 }
+
+// getClient returns the plugin's current go-plugin client. Every read and
+// write of p.client must go through getClient/setClient: spawnSubProcess
+// writes it, restartKilledProcess's watcher and Kill read it, and Disable
+// needs a consistent view of whichever one is racing it.
+func (p *DataSourcePlugin) getClient() *plugin.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.client
+}
+
+func (p *DataSourcePlugin) setClient(c *plugin.Client) {
+	p.mu.Lock()
+	p.client = c
+	p.mu.Unlock()
+}
+
+// Reload atomically disables and re-enables the plugin, waiting for any
+// queries already in flight to finish before the subprocess is swapped out.
+// Use it to pick up a new executable, manifest or signature without
+// restarting Grafana.
+//
+// The whole disable-wait-enable sequence runs under lifecycleMu so a
+// concurrent Enable/Disable (or the restart watcher's own respawn) can't
+// interleave with it — e.g. slip in an Enable between this Disable and
+// Wait and have Reload's Enable spawn a second subprocess on top of it.
+func (p *DataSourcePlugin) Reload(ctx context.Context) error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+
+	// Stop handing out the query endpoint first, let the queries already
+	// running finish, and only then kill the subprocess under them.
+	p.setState(PluginStateDisabled)
+	p.inFlight.Wait()
+
+	if err := p.disableLocked(ctx); err != nil {
+		return err
+	}
+
+	return p.enableLocked(ctx)
+}
+
 func (p *DataSourcePlugin) isVersionOne() bool {
 	return !p.SDK
 }
 
 func (p *DataSourcePlugin) spawnSubProcess() error {
+	if err := p.checkAcknowledgedPrivileges(); err != nil {
+		return err
+	}
+
 	cmd := ComposePluginStartCommmand(p.Executable)
-	fullpath := path.Join(p.PluginDir, cmd)
+	fullpath, err := resolvePluginExecutable(p.PluginDir, cmd)
+	if err != nil {
+		return err
+	}
+
+	p.crashes = &crashRecorder{}
+	logger := LogWrapper{Logger: p.log}
+	syncStderr := &stderrPanicScanner{recorder: p.crashes}
 
 	var newClient *plugin.Client
 	if p.isVersionOne() {
@@ -98,22 +280,51 @@ func (p *DataSourcePlugin) spawnSubProcess() error {
 			Plugins:          map[string]plugin.Plugin{p.Id: &datasourceV1.DatasourcePluginImpl{}},
 			Cmd:              exec.Command(fullpath),
 			AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
-			Logger:           LogWrapper{Logger: p.log},
+			Logger:           logger,
+			SyncStderr:       syncStderr,
 		})
 
 	} else {
+		cmd := exec.Command(fullpath)
+
+		// go-plugin itself owns stdout: Client.Start() calls
+		// cmd.StdoutPipe() to read the handshake line, which fails with
+		// "exec: Stdout already set" if we've already claimed it. Hand the
+		// plugin a dedicated pipe over ExtraFiles (fd 3) instead, so the
+		// frame multiplexer has its own channel that doesn't collide with
+		// the handshake or the gRPC connection.
+		framesReader, framesWriter, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		cmd.ExtraFiles = []*os.File{framesWriter}
+		cmd.Env = append(os.Environ(), "GRAFANA_PLUGIN_FRAMES_FD=3")
+
 		newClient = plugin.NewClient(&plugin.ClientConfig{
 			HandshakeConfig:  handshakeConfig,
 			Plugins:          map[string]plugin.Plugin{p.Id: &sdk.DatasourcePluginImpl{}},
-			Cmd:              exec.Command(fullpath),
+			Cmd:              cmd,
 			AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
-			Logger:           LogWrapper{Logger: p.log},
+			Logger:           logger,
+			SyncStderr:       syncStderr,
 		})
+
+		if _, err := newClient.Client(); err != nil {
+			framesWriter.Close()
+			framesReader.Close()
+			return err
+		}
+
+		// The subprocess holds its own copy of the write end; closing ours
+		// here means demuxStdio sees EOF exactly when the subprocess exits,
+		// instead of leaking a goroutine blocked on a fd nobody will close.
+		framesWriter.Close()
+		go p.demuxStdio(framesReader)
 	}
 
-	p.client = newClient
+	p.setClient(newClient)
 
-	rpcClient, err := p.client.Client()
+	rpcClient, err := newClient.Client()
 	if err != nil {
 		return err
 	}
@@ -127,7 +338,13 @@ func (p *DataSourcePlugin) spawnSubProcess() error {
 		plugin := raw.(datasourceV1.DatasourcePlugin)
 
 		tsdb.RegisterTsdbQueryEndpoint(p.Id, func(dsInfo *models.DataSource) (tsdb.TsdbQueryEndpoint, error) {
-			return wrapper.NewDatasourcePluginWrapper(p.log, plugin), nil
+			if !p.acquireInFlight() {
+				return nil, fmt.Errorf("datasource plugin %s is %s", p.Id, p.State())
+			}
+			return &stateTrackingQueryEndpoint{
+				TsdbQueryEndpoint: wrapper.NewDatasourcePluginWrapper(p.log, plugin),
+				inFlight:          &p.inFlight,
+			}, nil
 		})
 		return nil
 	}
@@ -138,14 +355,74 @@ func (p *DataSourcePlugin) spawnSubProcess() error {
 	}
 
 	tsdb.RegisterTsdbQueryEndpoint(p.Id, func(dsInfo *models.DataSource) (tsdb.TsdbQueryEndpoint, error) {
-		return wrapper.NewDatasourcePluginWrapperV2(p.log, plugin), nil
+		if !p.acquireInFlight() {
+			return nil, fmt.Errorf("datasource plugin %s is %s", p.Id, p.State())
+		}
+		return &stateTrackingQueryEndpoint{
+			TsdbQueryEndpoint: wrapper.NewDatasourcePluginWrapperV2(p.log, plugin),
+			inFlight:          &p.inFlight,
+			progress:          p.progress,
+		}, nil
 	})
 
 	return nil
 }
 
+// stateTrackingQueryEndpoint wraps a tsdb.TsdbQueryEndpoint so Reload can
+// wait for in-flight queries to finish before swapping the subprocess out
+// from under them.
+type stateTrackingQueryEndpoint struct {
+	tsdb.TsdbQueryEndpoint
+	inFlight *sync.WaitGroup
+
+	// progress is non-nil only for a v2 SDK plugin, which is the only kind
+	// that can send ProgressEvents over the multiplexed stdio channel.
+	progress *progressRouter
+}
+
+func (e *stateTrackingQueryEndpoint) Query(ctx context.Context, ds *models.DataSource, query *tsdb.TsdbQuery) (*tsdb.Response, error) {
+	// inFlight.Add happened in the factory that handed out this endpoint,
+	// atomically with the State() check — see acquireInFlight.
+	defer e.inFlight.Done()
+
+	// ctx carries the caller's query correlation id, if any — see
+	// ContextWithQueryID. Pre-creating its progress channel here, before the
+	// query is even sent to the plugin, closes the gap where a ProgressEvent
+	// arrives before StreamQueryProgress's first ProgressChannel(queryID)
+	// call: progressRouter.route only ever looks up channels that already
+	// exist, so without this a query that reports progress immediately would
+	// have its earliest events silently dropped. The id itself is not sent to
+	// the plugin from here; ctx is passed to the wrapped Query unchanged.
+	if e.progress != nil {
+		if queryID, ok := QueryIDFromContext(ctx); ok {
+			e.progress.Channel(queryID)
+		}
+	}
+
+	return e.TsdbQueryEndpoint.Query(ctx, ds, query)
+}
+
+// acquireInFlight reports whether the plugin is enabled and, if so,
+// registers a query as in-flight before the caller can act on that answer.
+// Checking State() and adding to inFlight under the same lock setState
+// uses keeps Reload's inFlight.Wait() from observing zero in-flight queries
+// while one is still on its way in — which would let Reload swap the
+// subprocess out from under it, and could re-Add to a WaitGroup a Wait has
+// already returned from.
+func (p *DataSourcePlugin) acquireInFlight() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != PluginStateEnabled {
+		return false
+	}
+	p.inFlight.Add(1)
+	return true
+}
+
 func (p *DataSourcePlugin) restartKilledProcess(ctx context.Context) error {
 	ticker := time.NewTicker(time.Second * 1)
+	defer ticker.Stop()
 
 	for {
 		select {
@@ -155,23 +432,66 @@ func (p *DataSourcePlugin) restartKilledProcess(ctx context.Context) error {
 			}
 			return nil
 		case <-ticker.C:
-			if !p.client.Exited() {
+			if p.State() != PluginStateEnabled {
 				continue
 			}
 
-			if err := p.spawnSubProcess(); err != nil {
-				p.log.Error("Failed to restart plugin", "err", err)
+			client := p.getClient()
+			if client == nil || !client.Exited() {
 				continue
 			}
 
-			p.log.Debug("Plugin process restarted")
+			trace := p.crashes.flush()
+			if trace != "" {
+				p.log.Error("Plugin process crashed", "trace", trace)
+			}
+
+			if tripped := p.recordCrash(trace); tripped {
+				p.log.Error("Plugin crashed too many times, giving up", "maxCrashes", maxCrashesInWindow, "window", crashWindow)
+				p.setState(PluginStateFailed)
+				return nil
+			}
+
+			backoff := p.restartBackoff()
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(backoff):
+			}
+
+			p.respawnAfterCrash(backoff)
 		}
 	}
 }
 
+// respawnAfterCrash re-spawns the subprocess once the backoff sleep is
+// over. An admin can call Disable, Enable or Reload during that (up to
+// restartMaxBackoff-long) window, so State() and the client's Exited() are
+// re-checked here, under lifecycleMu, rather than trusting the snapshot
+// that triggered the backoff: spawning unconditionally would otherwise
+// respawn a plugin that was just Disabled, or orphan the subprocess an
+// Enable/Reload already started underneath us.
+func (p *DataSourcePlugin) respawnAfterCrash(backoff time.Duration) {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+
+	client := p.getClient()
+	if p.State() != PluginStateEnabled || client == nil || !client.Exited() {
+		return
+	}
+
+	if err := p.spawnSubProcess(); err != nil {
+		p.log.Error("Failed to restart plugin", "err", err)
+		return
+	}
+
+	p.log.Debug("Plugin process restarted", "backoff", backoff)
+}
+
 func (p *DataSourcePlugin) Kill() {
-	if p.client != nil {
+	if client := p.getClient(); client != nil {
 		p.log.Debug("Killing subprocess ", "name", p.Name)
-		p.client.Kill()
+		p.setState(PluginStateDisabled)
+		client.Kill()
 	}
 }
@@ -0,0 +1,273 @@
+package plugins
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/setting"
+	"golang.org/x/xerrors"
+)
+
+// manifestFileName is the name the fetched PluginManifest is written under
+// inside a plugin's content-addressed install directory, alongside the
+// plugin's own plugin.json, so GetPluginPrivileges can re-read what an
+// admin acknowledged without going back to the registry.
+const manifestFileName = "grafana-manifest.json"
+
+// PluginsRegistryURL is the base URL bundles are fetched from. It defaults
+// to grafana.com's plugin API; tests can point it at a local server.
+var PluginsRegistryURL = "https://grafana.com/api/plugins"
+
+// TrustedSigningKey verifies a manifest's detached signature over its
+// SHA256 digest. Installs of manifests carrying a signature fail closed if
+// this is unset.
+var TrustedSigningKey ed25519.PublicKey
+
+// PluginManifest describes one installable release of a plugin, as served
+// by the configured registry.
+type PluginManifest struct {
+	Name       string           `json:"name"`
+	Version    string           `json:"version"`
+	URL        string           `json:"url"`
+	SHA256     string           `json:"sha256"`
+	Signature  string           `json:"signature,omitempty"`
+	Privileges PluginPrivileges `json:"privileges"`
+}
+
+// PluginPrivileges enumerates what a plugin's manifest declares it needs so
+// an admin can review them before the plugin is enabled.
+type PluginPrivileges struct {
+	NetworkRoutes []string `json:"networkRoutes,omitempty"`
+	Filesystem    []string `json:"filesystem,omitempty"`
+	Executables   []string `json:"executables,omitempty"`
+}
+
+// InstallPlugin fetches the named plugin version's manifest from the
+// configured registry, downloads its bundle, verifies its digest (and
+// signature, if the manifest carries one), unpacks it into a
+// content-addressed directory under setting.PluginsPath and registers it
+// through the normal Load path.
+func InstallPlugin(ctx context.Context, name, version string) (*DataSourcePlugin, error) {
+	manifest, err := fetchManifest(ctx, name, version)
+	if err != nil {
+		return nil, xerrors.Errorf("fetching manifest for %s@%s: %w", name, version, err)
+	}
+
+	bundle, err := fetchBundle(ctx, manifest.URL)
+	if err != nil {
+		return nil, xerrors.Errorf("downloading %s@%s: %w", name, version, err)
+	}
+
+	digest := sha256.Sum256(bundle)
+	sum := hex.EncodeToString(digest[:])
+	if sum != manifest.SHA256 {
+		return nil, xerrors.Errorf("digest mismatch for %s@%s: got %s, manifest says %s", name, version, sum, manifest.SHA256)
+	}
+
+	if manifest.Signature != "" {
+		if err := verifyManifestSignature(digest[:], manifest.Signature); err != nil {
+			return nil, xerrors.Errorf("signature verification failed for %s@%s: %w", name, version, err)
+		}
+	}
+
+	dir := filepath.Join(setting.PluginsPath, sum)
+	if err := unpackTarGz(bundle, dir); err != nil {
+		return nil, xerrors.Errorf("unpacking %s@%s: %w", name, version, err)
+	}
+
+	if err := writeManifest(dir, manifest); err != nil {
+		return nil, xerrors.Errorf("recording manifest for %s@%s: %w", name, version, err)
+	}
+
+	return loadPluginFromDir(dir)
+}
+
+// RemovePlugin kills a running backend plugin, stops its restart watcher,
+// drops it from the registry and deletes its install directory.
+func RemovePlugin(id string) error {
+	p, exists := GetDataSource(id)
+	if !exists {
+		return xerrors.Errorf("plugin %s not found", id)
+	}
+
+	if p.stopRestartWatcher != nil {
+		p.stopRestartWatcher()
+	}
+	p.Kill()
+	deleteDataSource(id)
+
+	return os.RemoveAll(p.PluginDir)
+}
+
+// GetPluginPrivileges returns the privileges an installed plugin's manifest
+// declared at install time.
+func GetPluginPrivileges(id string) (PluginPrivileges, error) {
+	p, exists := GetDataSource(id)
+	if !exists {
+		return PluginPrivileges{}, xerrors.Errorf("plugin %s not found", id)
+	}
+
+	f, err := os.Open(filepath.Join(p.PluginDir, manifestFileName))
+	if err != nil {
+		return PluginPrivileges{}, xerrors.Errorf("reading manifest for %s: %w", id, err)
+	}
+	defer f.Close()
+
+	var manifest PluginManifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return PluginPrivileges{}, xerrors.Errorf("decoding manifest for %s: %w", id, err)
+	}
+
+	return manifest.Privileges, nil
+}
+
+func fetchManifest(ctx context.Context, name, version string) (*PluginManifest, error) {
+	url := PluginsRegistryURL + "/" + name + "/versions/" + version
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("registry returned %s", resp.Status)
+	}
+
+	var manifest PluginManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+func fetchBundle(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("registry returned %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func verifyManifestSignature(digest []byte, signature string) error {
+	if len(TrustedSigningKey) == 0 {
+		return xerrors.New("no trusted signing key configured")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return xerrors.Errorf("decoding signature: %w", err)
+	}
+
+	if !ed25519.Verify(TrustedSigningKey, digest, sig) {
+		return xerrors.New("signature does not match")
+	}
+
+	return nil
+}
+
+// unpackTarGz extracts a gzipped tarball into dir. If dir already exists it
+// extracts nothing and returns nil: installs are content-addressed by the
+// bundle's digest, so a pre-existing directory means this exact version is
+// already installed.
+func unpackTarGz(bundle []byte, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.Clean(hdr.Name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(filepath.Separator)) {
+			return xerrors.Errorf("plugin bundle entry %q escapes install directory", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			out.Close()
+		}
+	}
+}
+
+func writeManifest(dir string, manifest *PluginManifest) error {
+	f, err := os.OpenFile(filepath.Join(dir, manifestFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(manifest)
+}
+
+func loadPluginFromDir(dir string) (*DataSourcePlugin, error) {
+	f, err := os.Open(filepath.Join(dir, "plugin.json"))
+	if err != nil {
+		return nil, xerrors.Errorf("opening plugin.json: %w", err)
+	}
+	defer f.Close()
+
+	p := &DataSourcePlugin{}
+	if err := p.Load(json.NewDecoder(f), dir); err != nil {
+		return nil, xerrors.Errorf("loading plugin: %w", err)
+	}
+
+	return p, nil
+}
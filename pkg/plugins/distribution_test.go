@@ -0,0 +1,201 @@
+package plugins
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+func buildTarGz(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+
+	for name, content := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0640, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUnpackTarGz_RejectsEscapingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		wantErr bool
+	}{
+		{"regular top-level file stays inside install dir", "plugin.json", false},
+		{"parent traversal escapes install dir", "../evil.sh", true},
+		{"nested traversal escapes install dir", "a/../../evil.sh", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "install")
+			bundle := buildTarGz(t, map[string]string{tt.entry: "content"})
+
+			err := unpackTarGz(bundle, dir)
+			if tt.wantErr && err == nil {
+				t.Fatalf("unpackTarGz(%q) expected an error, got nil", tt.entry)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("unpackTarGz(%q) unexpected error: %v", tt.entry, err)
+			}
+		})
+	}
+}
+
+func TestVerifyManifestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("bundle-contents"))
+	validSig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest[:]))
+	wrongKeySig := base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, digest[:]))
+
+	tests := []struct {
+		name      string
+		key       ed25519.PublicKey
+		signature string
+		wantErr   bool
+	}{
+		{"signature from the trusted key verifies", pub, validSig, false},
+		{"signature from an untrusted key is rejected", pub, wrongKeySig, true},
+		{"malformed base64 is rejected", pub, "not-valid-base64!!", true},
+		{"no trusted key configured fails closed", nil, validSig, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := TrustedSigningKey
+			TrustedSigningKey = tt.key
+			defer func() { TrustedSigningKey = old }()
+
+			err := verifyManifestSignature(digest[:], tt.signature)
+			if tt.wantErr && err == nil {
+				t.Fatal("verifyManifestSignature() expected an error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("verifyManifestSignature() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// newTestRegistry serves a single manifest (built from the given mutator)
+// plus its bundle, so InstallPlugin can be exercised end to end against a
+// registry we control.
+func newTestRegistry(t *testing.T, bundle []byte, mutate func(*PluginManifest)) *httptest.Server {
+	t.Helper()
+
+	digest := sha256.Sum256(bundle)
+	manifest := PluginManifest{
+		Name:    "test-plugin",
+		Version: "1.0.0",
+		SHA256:  hex.EncodeToString(digest[:]),
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/versions/") {
+			m := manifest
+			m.URL = srv.URL + "/bundle.tar.gz"
+			mutate(&m)
+			_ = json.NewEncoder(w).Encode(m)
+			return
+		}
+		_, _ = w.Write(bundle)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestInstallPlugin_RejectsDigestMismatch(t *testing.T) {
+	bundle := buildTarGz(t, map[string]string{"plugin.json": `{"id":"test-plugin"}`})
+	srv := newTestRegistry(t, bundle, func(m *PluginManifest) {
+		m.SHA256 = strings.Repeat("0", 64)
+	})
+
+	withTestRegistry(t, srv)
+
+	_, err := InstallPlugin(context.Background(), "test-plugin", "1.0.0")
+	if err == nil {
+		t.Fatal("InstallPlugin() expected a digest mismatch error, got nil")
+	}
+	if !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("InstallPlugin() expected a digest mismatch error, got: %v", err)
+	}
+}
+
+func TestInstallPlugin_RejectsBadSignature(t *testing.T) {
+	bundle := buildTarGz(t, map[string]string{"plugin.json": `{"id":"test-plugin"}`})
+	srv := newTestRegistry(t, bundle, func(m *PluginManifest) {
+		m.Signature = base64.StdEncoding.EncodeToString([]byte("not-a-real-signature-not-a-real-signature-1234"))
+	})
+
+	withTestRegistry(t, srv)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey := TrustedSigningKey
+	TrustedSigningKey = pub
+	defer func() { TrustedSigningKey = oldKey }()
+
+	_, err = InstallPlugin(context.Background(), "test-plugin", "1.0.0")
+	if err == nil {
+		t.Fatal("InstallPlugin() expected a signature verification error, got nil")
+	}
+	if !strings.Contains(err.Error(), "signature verification failed") {
+		t.Fatalf("InstallPlugin() expected a signature verification error, got: %v", err)
+	}
+}
+
+func withTestRegistry(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+
+	oldURL := PluginsRegistryURL
+	PluginsRegistryURL = srv.URL + "/api/plugins"
+	t.Cleanup(func() { PluginsRegistryURL = oldURL })
+
+	oldPath := setting.PluginsPath
+	setting.PluginsPath = t.TempDir()
+	t.Cleanup(func() { setting.PluginsPath = oldPath })
+}
+
@@ -0,0 +1,45 @@
+package plugins
+
+// PluginState describes where a backend datasource plugin is in its
+// lifecycle, from being read off disk through to being torn down or
+// quarantined after misbehaving.
+type PluginState int
+
+const (
+	// PluginStateLoaded means the plugin's manifest has been read and it is
+	// registered, but no backend process has been started yet.
+	PluginStateLoaded PluginState = iota
+	// PluginStateEnabled means the backend subprocess is running and the
+	// plugin is serving queries.
+	PluginStateEnabled
+	// PluginStateDisabled means the plugin was deliberately turned off by an
+	// admin; its subprocess is stopped and queries fail fast.
+	PluginStateDisabled
+	// PluginStateFailed means the plugin's backend subprocess could not be
+	// started, or the plugin was quarantined after repeatedly crashing; it
+	// won't be restarted automatically.
+	PluginStateFailed
+)
+
+func (s PluginState) String() string {
+	switch s {
+	case PluginStateLoaded:
+		return "loaded"
+	case PluginStateEnabled:
+		return "enabled"
+	case PluginStateDisabled:
+		return "disabled"
+	case PluginStateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// PluginStateChanged is published on the bus whenever a datasource plugin's
+// lifecycle state changes, so subscribers (e.g. the frontend over live) can
+// react without polling the admin API.
+type PluginStateChanged struct {
+	PluginId string
+	State    PluginState
+}
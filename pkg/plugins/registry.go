@@ -0,0 +1,31 @@
+package plugins
+
+import "sync"
+
+// dataSourcesMu guards the package-level DataSources registry, which is
+// written from Load/InstallPlugin/RemovePlugin and read from concurrent
+// admin HTTP handlers.
+var dataSourcesMu sync.RWMutex
+
+// GetDataSource looks up a registered datasource plugin by id.
+func GetDataSource(id string) (*DataSourcePlugin, bool) {
+	dataSourcesMu.RLock()
+	defer dataSourcesMu.RUnlock()
+
+	p, exists := DataSources[id]
+	return p, exists
+}
+
+func setDataSource(id string, p *DataSourcePlugin) {
+	dataSourcesMu.Lock()
+	defer dataSourcesMu.Unlock()
+
+	DataSources[id] = p
+}
+
+func deleteDataSource(id string) {
+	dataSourcesMu.Lock()
+	defer dataSourcesMu.Unlock()
+
+	delete(DataSources, id)
+}
@@ -0,0 +1,113 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// acknowledgedPrivilegesFileName is where a plugin's last admin-approved
+// privileges are recorded, alongside its plugin.json.
+const acknowledgedPrivilegesFileName = "acknowledged-privileges.json"
+
+// resolvePluginExecutable turns a plugin-supplied Executable field into an
+// absolute path guaranteed to live inside pluginDir, refusing to spawn
+// anything a malformed or malicious plugin.json tries to point outside of
+// it via "../" segments or a symlink.
+func resolvePluginExecutable(pluginDir, executable string) (string, error) {
+	absDir, err := filepath.Abs(pluginDir)
+	if err != nil {
+		return "", err
+	}
+
+	absPath, err := filepath.Abs(filepath.Join(absDir, executable))
+	if err != nil {
+		return "", err
+	}
+
+	if !isWithinDir(absDir, absPath) {
+		return "", fmt.Errorf("plugin executable %q escapes plugin directory %q", executable, pluginDir)
+	}
+
+	resolved, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", xerrors.Errorf("resolving plugin executable: %w", err)
+	}
+	if !isWithinDir(absDir, resolved) {
+		return "", fmt.Errorf("plugin executable %q resolves outside plugin directory via a symlink", executable)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("plugin executable %q is not executable", executable)
+	}
+	if info.Mode()&0022 != 0 {
+		return "", fmt.Errorf("plugin executable %q is group- or world-writable, refusing to run it", executable)
+	}
+
+	return resolved, nil
+}
+
+// isWithinDir reports whether path is dir itself or a descendant of it.
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkAcknowledgedPrivileges refuses to start the plugin if it declares
+// privileges (network routes, filesystem paths, allowed executables) that
+// haven't been acknowledged, or that have
+// escalated since they last were, via AcknowledgePrivileges.
+func (p *DataSourcePlugin) checkAcknowledgedPrivileges() error {
+	if isZeroPrivileges(p.Privileges) {
+		return nil
+	}
+
+	f, err := os.Open(filepath.Join(p.PluginDir, acknowledgedPrivilegesFileName))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("plugin %s declares privileges that have not been acknowledged by an admin", p.Id)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var acknowledged PluginPrivileges
+	if err := json.NewDecoder(f).Decode(&acknowledged); err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(acknowledged, p.Privileges) {
+		return fmt.Errorf("plugin %s privileges have escalated since they were last acknowledged, refusing to start", p.Id)
+	}
+
+	return nil
+}
+
+func isZeroPrivileges(pr PluginPrivileges) bool {
+	return len(pr.NetworkRoutes) == 0 && len(pr.Filesystem) == 0 && len(pr.Executables) == 0
+}
+
+// AcknowledgePrivileges records the plugin's currently declared privileges
+// as admin-approved, allowing it to start. Call it again after upgrading a
+// plugin whose declared privileges changed.
+func (p *DataSourcePlugin) AcknowledgePrivileges() error {
+	f, err := os.OpenFile(filepath.Join(p.PluginDir, acknowledgedPrivilegesFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(p.Privileges)
+}
@@ -0,0 +1,67 @@
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePluginExecutable(t *testing.T) {
+	dir := t.TempDir()
+
+	goodExe := filepath.Join(dir, "plugin_linux_amd64")
+	if err := os.WriteFile(goodExe, []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	notExecutable := filepath.Join(dir, "not_executable")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	worldWritable := filepath.Join(dir, "world_writable")
+	if err := os.WriteFile(worldWritable, []byte("#!/bin/sh\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile's mode is masked by the umask; force the writable bits.
+	if err := os.Chmod(worldWritable, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	outsideDir := t.TempDir()
+	outsideExe := filepath.Join(outsideDir, "evil")
+	if err := os.WriteFile(outsideExe, []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	escapingSymlink := filepath.Join(dir, "escaping_symlink")
+	if err := os.Symlink(outsideExe, escapingSymlink); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		executable string
+		wantErr    bool
+	}{
+		{"plain executable inside plugin dir resolves", "plugin_linux_amd64", false},
+		{"parent traversal escapes plugin dir", "../evil", true},
+		{"nested traversal escapes plugin dir", "sub/../../evil", true},
+		{"symlink escaping plugin dir is refused", "escaping_symlink", true},
+		{"non-executable file is refused", "not_executable", true},
+		{"world-writable file is refused", "world_writable", true},
+		{"missing file errors", "does_not_exist", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolvePluginExecutable(dir, tt.executable)
+			if tt.wantErr && err == nil {
+				t.Fatalf("resolvePluginExecutable(%q) expected an error, got nil", tt.executable)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("resolvePluginExecutable(%q) unexpected error: %v", tt.executable, err)
+			}
+		})
+	}
+}
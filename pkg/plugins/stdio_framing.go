@@ -0,0 +1,252 @@
+package plugins
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Frame types carried over the multiplexed stdio protocol. A v2 SDK plugin
+// writes these to the dedicated pipe it is handed as fd 3
+// (GRAFANA_PLUGIN_FRAMES_FD), not to its stdout, which go-plugin keeps for
+// the handshake; so logs, metrics and query progress can reach Grafana
+// without blocking or interleaving with the gRPC channel.
+const (
+	frameTypeLog      uint8 = 1
+	frameTypeMetric   uint8 = 2
+	frameTypeProgress uint8 = 3
+)
+
+// frameHeaderSize is the length-prefixed header: a 4-byte stream id, a
+// 1-byte frame type and a 4-byte payload length, all big-endian.
+const frameHeaderSize = 9
+
+// maxFrameLength bounds a single multiplexed stdio frame's payload. The
+// length prefix is plugin-supplied and otherwise untrusted; without a cap a
+// buggy or hostile plugin could claim a 4 GB frame and have Grafana
+// allocate it.
+const maxFrameLength = 4 << 20 // 4 MiB
+
+// LogRecord is a structured log line sent by a plugin over a log frame.
+type LogRecord struct {
+	Level  string                 `json:"level"`
+	Msg    string                 `json:"msg"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// MetricSample is a single Prometheus-style sample sent by a plugin over a
+// metric frame.
+type MetricSample struct {
+	Name   string            `json:"name"`
+	Value  float64           `json:"value"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Time   time.Time         `json:"time"`
+}
+
+// ProgressEvent reports how far a long-running query has gotten, sent by a
+// plugin over a progress frame.
+type ProgressEvent struct {
+	QueryId string  `json:"queryId"`
+	Percent float64 `json:"percent"`
+	Message string  `json:"message,omitempty"`
+}
+
+type queryIDContextKey struct{}
+
+// ContextWithQueryID attaches a client-chosen query correlation id to ctx.
+// The HTTP handler that accepts a query request and a client's own id for
+// it must call this before invoking tsdb.TsdbQueryEndpoint.Query; a v2 SDK
+// plugin's endpoint then reads it back with QueryIDFromContext and
+// pre-creates that query's progress channel. progressRouter.route matches
+// a progress frame to a channel by the ProgressEvent's QueryId, so only
+// events the plugin tags with that same id reach StreamQueryProgress;
+// events for an id with no channel are dropped. This package does not
+// pass the id on to the plugin itself: ctx goes to the wrapped endpoint's
+// Query unchanged.
+func ContextWithQueryID(ctx context.Context, queryID string) context.Context {
+	return context.WithValue(ctx, queryIDContextKey{}, queryID)
+}
+
+// QueryIDFromContext returns the query id ContextWithQueryID attached to
+// ctx, if any.
+func QueryIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(queryIDContextKey{}).(string)
+	return id, ok
+}
+
+// metricsRegistry is a small in-memory store of the most recent sample per
+// metric name; each plugin has its own.
+type metricsRegistry struct {
+	mu      sync.RWMutex
+	samples map[string]MetricSample
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{samples: map[string]MetricSample{}}
+}
+
+func (r *metricsRegistry) record(s MetricSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[s.Name] = s
+}
+
+// Snapshot returns the most recent sample for every metric name the plugin
+// has reported.
+func (r *metricsRegistry) Snapshot() []MetricSample {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]MetricSample, 0, len(r.samples))
+	for _, s := range r.samples {
+		out = append(out, s)
+	}
+	return out
+}
+
+// progressRouter hands each query's progress frames to whoever is waiting
+// on that query, so a streaming HTTP handler (StreamQueryProgress, via
+// ProgressChannel) can surface them.
+type progressRouter struct {
+	mu  sync.Mutex
+	chs map[string]chan ProgressEvent
+}
+
+func newProgressRouter() *progressRouter {
+	return &progressRouter{chs: map[string]chan ProgressEvent{}}
+}
+
+// Channel returns the progress channel for a query, creating it if this is
+// the first caller to ask for it.
+func (r *progressRouter) Channel(queryID string) chan ProgressEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.chs[queryID]
+	if !ok {
+		ch = make(chan ProgressEvent, 16)
+		r.chs[queryID] = ch
+	}
+	return ch
+}
+
+// Close removes a query's progress channel once the caller is done
+// streaming its results.
+func (r *progressRouter) Close(queryID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ch, ok := r.chs[queryID]; ok {
+		close(ch)
+		delete(r.chs, queryID)
+	}
+}
+
+func (r *progressRouter) route(e ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.chs[e.QueryId]
+	if !ok {
+		return
+	}
+
+	// Close also takes r.mu, so holding it across the send (instead of just
+	// the map lookup) rules out a send landing after the channel's been
+	// closed out from under us — a closed channel's send case is "ready" in
+	// a select, so a bare "default" doesn't save us from that panic.
+	select {
+	case ch <- e:
+	default:
+		// A slow or absent consumer shouldn't stall the demux loop; drop
+		// the update, the next one will supersede it anyway.
+	}
+}
+
+// MetricSamples returns a snapshot of the plugin's metric registry, so
+// callers (e.g. an admin debug endpoint) can see what a v2 SDK plugin has
+// reported. It can't be named Metrics: that's already the plugin.json
+// "metrics" capability field.
+func (p *DataSourcePlugin) MetricSamples() []MetricSample {
+	return p.metrics.Snapshot()
+}
+
+// ProgressChannel returns the channel progress updates for queryID will be
+// delivered on. Callers must call CloseProgressChannel(queryID) once done
+// consuming it.
+func (p *DataSourcePlugin) ProgressChannel(queryID string) <-chan ProgressEvent {
+	return p.progress.Channel(queryID)
+}
+
+// CloseProgressChannel releases the progress channel for queryID.
+func (p *DataSourcePlugin) CloseProgressChannel(queryID string) {
+	p.progress.Close(queryID)
+}
+
+// demuxStdio reads length-prefixed frames off a v2 SDK plugin's fd 3 frames
+// pipe until it's closed (the subprocess exited or the pipe was torn down),
+// routing each frame by type. It never blocks the gRPC channel, which is a
+// separate connection the plugin opened itself.
+func (p *DataSourcePlugin) demuxStdio(r io.Reader) {
+	header := make([]byte, frameHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+
+		frameType := header[4]
+		length := binary.BigEndian.Uint32(header[5:9])
+		if length > maxFrameLength {
+			p.log.Error("Plugin sent an oversized stdio frame, disconnecting frame stream", "length", length, "max", maxFrameLength)
+			return
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+
+		switch frameType {
+		case frameTypeLog:
+			var rec LogRecord
+			if err := json.Unmarshal(payload, &rec); err != nil {
+				continue
+			}
+			p.logRecord(rec)
+		case frameTypeMetric:
+			var sample MetricSample
+			if err := json.Unmarshal(payload, &sample); err != nil {
+				continue
+			}
+			p.metrics.record(sample)
+		case frameTypeProgress:
+			var evt ProgressEvent
+			if err := json.Unmarshal(payload, &evt); err != nil {
+				continue
+			}
+			p.progress.route(evt)
+		}
+	}
+}
+
+func (p *DataSourcePlugin) logRecord(rec LogRecord) {
+	args := make([]interface{}, 0, len(rec.Fields)*2)
+	for k, v := range rec.Fields {
+		args = append(args, k, v)
+	}
+
+	switch rec.Level {
+	case "debug":
+		p.log.Debug(rec.Msg, args...)
+	case "warn":
+		p.log.Warn(rec.Msg, args...)
+	case "error":
+		p.log.Error(rec.Msg, args...)
+	default:
+		p.log.Info(rec.Msg, args...)
+	}
+}
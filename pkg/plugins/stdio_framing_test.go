@@ -0,0 +1,21 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueryIDContext(t *testing.T) {
+	if _, ok := QueryIDFromContext(context.Background()); ok {
+		t.Fatal("QueryIDFromContext(context.Background()) expected ok=false, got true")
+	}
+
+	ctx := ContextWithQueryID(context.Background(), "abc-123")
+	id, ok := QueryIDFromContext(ctx)
+	if !ok {
+		t.Fatal("QueryIDFromContext() expected ok=true after ContextWithQueryID")
+	}
+	if id != "abc-123" {
+		t.Fatalf("QueryIDFromContext() = %q, want %q", id, "abc-123")
+	}
+}